@@ -0,0 +1,43 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package oci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/container-orchestrated-devices/container-device-interface/pkg/cdi"
+	"github.com/containerd/containerd/containers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// WithCDIDevices injects the given fully-qualified CDI device names into s,
+// merging each device's containerEdits (env, mounts, device nodes, hooks)
+// from the CDI registry. It is a no-op if devices is empty.
+func WithCDIDevices(devices ...string) SpecOpts {
+	return func(_ context.Context, _ Client, _ *containers.Container, s *specs.Spec) error {
+		if len(devices) == 0 {
+			return nil
+		}
+
+		registry := cdi.GetRegistry()
+		if _, err := registry.InjectDevices(s, devices...); err != nil {
+			return fmt.Errorf("failed to inject CDI devices %v: %w", devices, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,40 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package config declares the subset of the CRI plugin's configuration that
+// the QoS resource provider support in pkg/cri/server reads from
+// criService.config. It does not attempt to restate the rest of the CRI
+// plugin's configuration surface.
+package config
+
+// ContainerdConfig holds the containerd-specific options of the CRI plugin
+// configuration.
+type ContainerdConfig struct {
+	// CDISpecDirs overrides the default CDI spec search paths (/etc/cdi,
+	// /var/run/cdi) used to discover CDI-backed QoS resource providers. A
+	// nil or empty value falls back to those defaults.
+	CDISpecDirs []string
+
+	// QoSProviderPluginDir, if non-empty, is scanned at startup for Go
+	// plugin (.so) files implementing QoSResourceProvider, which are
+	// registered alongside the built-in providers.
+	QoSProviderPluginDir string
+}
+
+// Config is the CRI plugin's configuration.
+type Config struct {
+	ContainerdConfig ContainerdConfig
+}
@@ -19,9 +19,11 @@
 package server
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/containerd/containerd/pkg/blockio"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -56,3 +58,72 @@ func (c *criService) getContainerBlockioClass(config *runtime.ContainerConfig, s
 
 	return
 }
+
+// blockioQoSProvider is the QoSResourceProvider for the built-in blockio
+// resource type. Class resolution still goes through getContainerBlockioClass,
+// which also consults annotations as a fallback; this provider only
+// supplies the class list and applies the final, already-resolved class.
+type blockioQoSProvider struct{}
+
+func (blockioQoSProvider) Name() string { return runtime.QoSResourceBlockio }
+func (blockioQoSProvider) Level() Scope { return ContainerScope }
+
+func (blockioQoSProvider) Classes() []Class {
+	names := blockio.GetClasses()
+	out := make([]Class, len(names))
+	for i, n := range names {
+		out[i] = Class{Name: n}
+	}
+	return out
+}
+
+func (blockioQoSProvider) Apply(class string, spec *specs.Spec) error {
+	if !blockio.IsEnabled() {
+		return fmt.Errorf("blockio disabled, refusing to set blockio class to %q", class)
+	}
+	linuxBlockIO, err := blockio.ClassNameToLinuxOCI(class)
+	if err != nil {
+		return err
+	}
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = &specs.LinuxResources{}
+	}
+	spec.Linux.Resources.BlockIO = linuxBlockIO
+	return nil
+}
+
+// Watch is a no-op: blockio classes come from static containerd
+// configuration and only change on a config reload.
+func (blockioQoSProvider) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// Mutable is true: a running container can be moved to a different
+// blockio class by rewriting its blkio.bfq.weight cgroup group
+// membership, without restarting it.
+func (blockioQoSProvider) Mutable() bool { return true }
+
+// moveContainerBlockioClass moves the already-running container
+// containerID into class by rewriting its blkio.bfq.weight cgroup group
+// membership, without restarting it.
+func moveContainerBlockioClass(containerID, class string) error {
+	if !blockio.IsEnabled() {
+		return fmt.Errorf("blockio disabled, refusing to move container %q to class %q", containerID, class)
+	}
+	if !blockio.ClassExists(class) {
+		return fmt.Errorf("invalid blockio class %q: not specified in configuration", class)
+	}
+	return blockio.SetContainerClass(containerID, class)
+}
+
+func init() {
+	RegisterQoSProvider(blockioQoSProvider{})
+}
@@ -0,0 +1,211 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// ErrQoSCapacityExhausted is returned when a QoS resource class has no
+// remaining capacity for a new assignment.
+type ErrQoSCapacityExhausted struct {
+	Resource string
+	Class    string
+	Capacity uint64
+}
+
+func (e *ErrQoSCapacityExhausted) Error() string {
+	return fmt.Sprintf("QoS resource %q class %q is at capacity (%d)", e.Resource, e.Class, e.Capacity)
+}
+
+// qosAllocator tracks live pod and container assignments per (resource,
+// class) and enforces the capacity declared by the owning provider.
+type qosAllocator struct {
+	mu sync.Mutex
+	// usage maps resource -> class -> the set of sandbox/container IDs
+	// currently holding that class, so releases are idempotent and
+	// recovery on restart can simply replay reserve() for every owner.
+	usage map[string]map[string]map[string]struct{}
+}
+
+// qosAllocators is the process-wide allocator, keyed by QoS scope, since
+// pod-level and container-level resources are independent namespaces.
+var (
+	qosAllocatorsMu sync.Mutex
+	qosAllocators   = map[string]*qosAllocator{
+		"pod":       newQoSAllocator(),
+		"container": newQoSAllocator(),
+	}
+)
+
+func newQoSAllocator() *qosAllocator {
+	return &qosAllocator{usage: make(map[string]map[string]map[string]struct{})}
+}
+
+// reserve records that owner (a sandbox or container ID) is using class of
+// resource, enforcing capacity. A zero capacity means unlimited. Reserving
+// an (resource, class) that owner already holds is a no-op.
+func (a *qosAllocator) reserve(resource, class, owner string, capacity uint64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	classes, ok := a.usage[resource]
+	if !ok {
+		classes = make(map[string]map[string]struct{})
+		a.usage[resource] = classes
+	}
+	owners, ok := classes[class]
+	if !ok {
+		owners = make(map[string]struct{})
+		classes[class] = owners
+	}
+
+	if _, ok := owners[owner]; ok {
+		return nil
+	}
+
+	if capacity > 0 && uint64(len(owners)) >= capacity {
+		return &ErrQoSCapacityExhausted{Resource: resource, Class: class, Capacity: capacity}
+	}
+
+	owners[owner] = struct{}{}
+	return nil
+}
+
+// release removes owner's assignment of class of resource, if any.
+func (a *qosAllocator) release(resource, class, owner string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if owners, ok := a.usage[resource][class]; ok {
+		delete(owners, owner)
+	}
+}
+
+// classFor returns the class owner currently holds for resource, if any.
+// It is used when reclassifying a running pod or container, so the old
+// class's usage can be released once the new one is reserved.
+func (a *qosAllocator) classFor(resource, owner string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for class, owners := range a.usage[resource] {
+		if _, ok := owners[owner]; ok {
+			return class, true
+		}
+	}
+	return "", false
+}
+
+// usageFor returns a snapshot of current usage counts for resource.
+func (a *qosAllocator) usageFor(resource string) map[string]uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make(map[string]uint64, len(a.usage[resource]))
+	for class, owners := range a.usage[resource] {
+		out[class] = uint64(len(owners))
+	}
+	return out
+}
+
+func podQoSAllocator() *qosAllocator       { return qosAllocators["pod"] }
+func containerQoSAllocator() *qosAllocator { return qosAllocators["container"] }
+
+// qosClassCapacity looks up the declared capacity of class for resource in
+// infos (the pod-level or the container-level QoS resource info). A missing
+// entry means unlimited capacity.
+func qosClassCapacity(infos []*runtime.QOSResourceInfo, resource, class string) uint64 {
+	for _, info := range infos {
+		if info.GetName() != resource {
+			continue
+		}
+		for _, c := range info.GetClasses() {
+			if c.GetName() == class {
+				return c.GetCapacity()
+			}
+		}
+	}
+	return 0
+}
+
+// recoverQoSAllocations rebuilds the allocation tables from the CRI store
+// so capacity accounting survives a containerd restart. It is called once,
+// from initQoSResourceProviders on the first call to
+// ensureQoSResourceProvidersInitialized, by which point the sandbox and
+// container stores have been loaded from disk.
+func (c *criService) recoverQoSAllocations(ctx context.Context) error {
+	podAlloc, containerAlloc := podQoSAllocator(), containerQoSAllocator()
+	podInfos, containerInfos := GetPodQoSResourcesInfo(), GetContainerQoSResourcesInfo()
+
+	for _, sb := range c.sandboxStore.List() {
+		for _, r := range sb.Config.GetQosResources() {
+			name, class := r.GetName(), r.GetClass()
+			if class == "" {
+				continue
+			}
+			// Account "net" under its canonical class name, as
+			// generateCniQoSResourceOpts does.
+			if name == QoSResourceNet {
+				if nc, ok := cniQoSResource[class]; ok {
+					class = nc.canonical
+				}
+			}
+			capacity := qosClassCapacity(podInfos, name, class)
+			if err := podAlloc.reserve(name, class, sb.ID, capacity); err != nil {
+				return fmt.Errorf("failed to recover pod QoS allocation for sandbox %s: %w", sb.ID, err)
+			}
+		}
+	}
+
+	for _, cntr := range c.containerStore.List() {
+		for _, r := range cntr.Config.GetQosResources() {
+			name, class := r.GetName(), r.GetClass()
+			if class == "" {
+				continue
+			}
+			capacity := qosClassCapacity(containerInfos, name, class)
+			if err := containerAlloc.reserve(name, class, cntr.ID, capacity); err != nil {
+				return fmt.Errorf("failed to recover container QoS allocation for container %s: %w", cntr.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetQoSResourceUsage returns the current number of pods and containers
+// assigned to each QoS resource class, alongside GetPodQoSResourcesInfo and
+// GetContainerQoSResourcesInfo.
+func GetQoSResourceUsage() map[string]map[string]uint64 {
+	usage := make(map[string]map[string]uint64)
+
+	for _, info := range GetPodQoSResourcesInfo() {
+		usage[info.GetName()] = podQoSAllocator().usageFor(info.GetName())
+	}
+	for _, info := range GetContainerQoSResourcesInfo() {
+		usage[info.GetName()] = containerQoSAllocator().usageFor(info.GetName())
+	}
+
+	return usage
+}
@@ -0,0 +1,137 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import "testing"
+
+func TestQoSAllocatorReserveEnforcesCapacity(t *testing.T) {
+	a := newQoSAllocator()
+
+	if err := a.reserve("net", "gold", "pod-1", 1); err != nil {
+		t.Fatalf("reserve pod-1: unexpected error: %v", err)
+	}
+
+	if err := a.reserve("net", "gold", "pod-2", 1); err == nil {
+		t.Fatalf("reserve pod-2: expected capacity error, got nil")
+	} else if _, ok := err.(*ErrQoSCapacityExhausted); !ok {
+		t.Fatalf("reserve pod-2: expected *ErrQoSCapacityExhausted, got %T: %v", err, err)
+	}
+}
+
+func TestQoSAllocatorReserveSameOwnerIsNoop(t *testing.T) {
+	a := newQoSAllocator()
+
+	if err := a.reserve("net", "gold", "pod-1", 1); err != nil {
+		t.Fatalf("first reserve: unexpected error: %v", err)
+	}
+	if err := a.reserve("net", "gold", "pod-1", 1); err != nil {
+		t.Fatalf("repeat reserve of the same owner: unexpected error: %v", err)
+	}
+}
+
+func TestQoSAllocatorZeroCapacityIsUnlimited(t *testing.T) {
+	a := newQoSAllocator()
+
+	for i := 0; i < 10; i++ {
+		if err := a.reserve("net", "gold", string(rune('a'+i)), 0); err != nil {
+			t.Fatalf("reserve %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestQoSAllocatorReleaseFreesCapacity(t *testing.T) {
+	a := newQoSAllocator()
+
+	if err := a.reserve("net", "gold", "pod-1", 1); err != nil {
+		t.Fatalf("reserve pod-1: unexpected error: %v", err)
+	}
+	a.release("net", "gold", "pod-1")
+
+	if err := a.reserve("net", "gold", "pod-2", 1); err != nil {
+		t.Fatalf("reserve pod-2 after release: unexpected error: %v", err)
+	}
+}
+
+func TestQoSAllocatorReleaseUnknownOwnerIsNoop(t *testing.T) {
+	a := newQoSAllocator()
+	a.release("net", "gold", "pod-1")
+}
+
+func TestQoSAllocatorClassFor(t *testing.T) {
+	a := newQoSAllocator()
+
+	if _, ok := a.classFor("net", "pod-1"); ok {
+		t.Fatalf("classFor on empty allocator: expected ok == false")
+	}
+
+	if err := a.reserve("net", "gold", "pod-1", 0); err != nil {
+		t.Fatalf("reserve: unexpected error: %v", err)
+	}
+
+	class, ok := a.classFor("net", "pod-1")
+	if !ok || class != "gold" {
+		t.Fatalf("classFor: got (%q, %v), want (\"gold\", true)", class, ok)
+	}
+}
+
+func TestQoSAllocatorUsageFor(t *testing.T) {
+	a := newQoSAllocator()
+
+	if err := a.reserve("net", "gold", "pod-1", 0); err != nil {
+		t.Fatalf("reserve pod-1: unexpected error: %v", err)
+	}
+	if err := a.reserve("net", "gold", "pod-2", 0); err != nil {
+		t.Fatalf("reserve pod-2: unexpected error: %v", err)
+	}
+	if err := a.reserve("net", "silver", "pod-3", 0); err != nil {
+		t.Fatalf("reserve pod-3: unexpected error: %v", err)
+	}
+
+	usage := a.usageFor("net")
+	if usage["gold"] != 2 {
+		t.Errorf("usage[gold] = %d, want 2", usage["gold"])
+	}
+	if usage["silver"] != 1 {
+		t.Errorf("usage[silver] = %d, want 1", usage["silver"])
+	}
+}
+
+func TestQoSAllocatorReclassifyDoesNotLeakOldClass(t *testing.T) {
+	a := newQoSAllocator()
+
+	if err := a.reserve("net", "gold", "pod-1", 1); err != nil {
+		t.Fatalf("reserve gold: unexpected error: %v", err)
+	}
+
+	oldClass, ok := a.classFor("net", "pod-1")
+	if !ok || oldClass != "gold" {
+		t.Fatalf("classFor before reclassify: got (%q, %v), want (\"gold\", true)", oldClass, ok)
+	}
+
+	if err := a.reserve("net", "silver", "pod-1", 1); err != nil {
+		t.Fatalf("reserve silver: unexpected error: %v", err)
+	}
+	if ok && oldClass != "silver" {
+		a.release("net", oldClass, "pod-1")
+	}
+
+	if err := a.reserve("net", "gold", "pod-2", 1); err != nil {
+		t.Fatalf("reserve gold for pod-2 after pod-1 moved away: unexpected error: %v", err)
+	}
+}
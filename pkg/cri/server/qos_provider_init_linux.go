@@ -0,0 +1,64 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+var (
+	qosResourceProvidersOnce sync.Once
+	qosResourceProvidersErr  error
+)
+
+// ensureQoSResourceProvidersInitialized lazily performs the one-time QoS
+// resource provider setup that needs criService's config: scanning CDI spec
+// dirs for device-backed providers and loading vendor plugins. It is safe
+// to call from every request path that needs up-to-date QoS resource info;
+// only the first call in the process does any work, with the config of the
+// criService that made it.
+func (c *criService) ensureQoSResourceProvidersInitialized(ctx context.Context) error {
+	qosResourceProvidersOnce.Do(func() {
+		qosResourceProvidersErr = c.initQoSResourceProviders(ctx)
+	})
+	return qosResourceProvidersErr
+}
+
+// initQoSResourceProviders wires up the QoS resource providers that need
+// per-instance configuration: CDI spec search paths and vendor-supplied
+// Go plugins. It then rebuilds the allocator's capacity accounting from the
+// sandboxes and containers already in the CRI store, since this is the
+// first point at which both the store and the providers it needs are
+// available.
+func (c *criService) initQoSResourceProviders(ctx context.Context) error {
+	if err := c.initCDIQoSResources(c.config.ContainerdConfig.CDISpecDirs); err != nil {
+		return fmt.Errorf("failed to initialize CDI QoS resources: %w", err)
+	}
+	if dir := c.config.ContainerdConfig.QoSProviderPluginDir; dir != "" {
+		if err := LoadQoSProviderPlugins(dir); err != nil {
+			return fmt.Errorf("failed to load QoS resource provider plugins: %w", err)
+		}
+	}
+	if err := c.recoverQoSAllocations(ctx); err != nil {
+		return fmt.Errorf("failed to recover QoS resource allocations: %w", err)
+	}
+	return nil
+}
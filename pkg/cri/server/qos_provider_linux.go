@@ -0,0 +1,188 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/oci"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// Scope identifies whether a QoS resource is assigned at the pod-sandbox
+// level or at the individual container level.
+type Scope int
+
+const (
+	// PodScope providers handle QoS resources requested on a
+	// PodSandboxConfig.
+	PodScope Scope = iota
+	// ContainerScope providers handle QoS resources requested on a
+	// ContainerConfig.
+	ContainerScope
+)
+
+func (s Scope) String() string {
+	switch s {
+	case PodScope:
+		return "pod"
+	case ContainerScope:
+		return "container"
+	default:
+		return "unknown"
+	}
+}
+
+// Class describes a single selectable class of a QoS resource, e.g. the
+// "gold" class of a "net" resource.
+type Class struct {
+	Name     string
+	Capacity uint64
+}
+
+// EventKind identifies the kind of change a QoSResourceProvider reports
+// through Watch.
+type EventKind int
+
+const (
+	// EventClassesChanged indicates the set of classes returned by
+	// Classes has changed, and consumers should call it again.
+	EventClassesChanged EventKind = iota
+)
+
+// Event is sent on the channel returned by QoSResourceProvider.Watch.
+type Event struct {
+	Kind EventKind
+}
+
+// QoSResourceProvider is implemented by anything that can supply and apply
+// a QoS resource type's classes. Providers are registered with
+// RegisterQoSProvider and dispatched to from generateSandboxQoSResourceSpecOpts
+// and generateContainerQoSResourceSpecOpts.
+type QoSResourceProvider interface {
+	// Name is the QoS resource name, e.g. "net" or "rdt".
+	Name() string
+	// Level reports whether this resource is assigned at pod or
+	// container scope.
+	Level() Scope
+	// Classes lists the currently available classes for this resource.
+	Classes() []Class
+	// Apply mutates spec so the container is placed in class. Apply is
+	// only called for ContainerScope providers; PodScope providers are
+	// applied through their own provider-specific SpecOpts or CNI
+	// namespace options.
+	Apply(class string, spec *specs.Spec) error
+	// Watch returns a channel that receives an Event whenever Classes
+	// changes. The channel is closed when ctx is done.
+	Watch(ctx context.Context) <-chan Event
+	// Mutable reports whether a running pod or container can be moved
+	// between classes of this resource without being recreated. It is
+	// forwarded truthfully by GetPodQoSResourcesInfo and
+	// GetContainerQoSResourcesInfo.
+	Mutable() bool
+}
+
+var (
+	qosProviderRegistryMu sync.RWMutex
+	qosProviderRegistry   = map[string]QoSResourceProvider{}
+)
+
+// RegisterQoSProvider registers p under p.Name(). Registering a second
+// provider under the same name replaces the first, so out-of-tree plugins
+// can override a built-in provider if needed.
+func RegisterQoSProvider(p QoSResourceProvider) {
+	qosProviderRegistryMu.Lock()
+	defer qosProviderRegistryMu.Unlock()
+
+	if _, exists := qosProviderRegistry[p.Name()]; exists {
+		log.L.Infof("QoS resource provider %q replaced by a later registration", p.Name())
+	}
+	qosProviderRegistry[p.Name()] = p
+}
+
+// UnregisterQoSProvider removes the provider registered under name, if any.
+// It is used by providers whose set of resource names changes at runtime,
+// such as the CDI provider reacting to spec files appearing or disappearing.
+func UnregisterQoSProvider(name string) {
+	qosProviderRegistryMu.Lock()
+	defer qosProviderRegistryMu.Unlock()
+	delete(qosProviderRegistry, name)
+}
+
+// qosProvider looks up the provider registered for name.
+func qosProvider(name string) (QoSResourceProvider, bool) {
+	qosProviderRegistryMu.RLock()
+	defer qosProviderRegistryMu.RUnlock()
+	p, ok := qosProviderRegistry[name]
+	return p, ok
+}
+
+// qosProvidersAt returns every registered provider at the given scope, in
+// no particular order.
+func qosProvidersAt(level Scope) []QoSResourceProvider {
+	qosProviderRegistryMu.RLock()
+	defer qosProviderRegistryMu.RUnlock()
+
+	out := make([]QoSResourceProvider, 0, len(qosProviderRegistry))
+	for _, p := range qosProviderRegistry {
+		if p.Level() == level {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// qosResourceInfoFor converts a provider's classes into the CRI wire type.
+func qosResourceInfoFor(p QoSResourceProvider) *runtime.QOSResourceInfo {
+	classes := p.Classes()
+	out := make([]*runtime.QOSResourceClassInfo, len(classes))
+	for i, c := range classes {
+		out[i] = &runtime.QOSResourceClassInfo{Name: c.Name, Capacity: c.Capacity}
+	}
+	return &runtime.QOSResourceInfo{Name: p.Name(), Mutable: p.Mutable(), Classes: out}
+}
+
+// qosProviderSpecOpt adapts a container-scope provider's Apply method into
+// an oci.SpecOpts so it can be collected alongside the other SpecOpts
+// generateContainerQoSResourceSpecOpts returns.
+func qosProviderSpecOpt(p QoSResourceProvider, class string) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *specs.Spec) error {
+		return p.Apply(class, s)
+	}
+}
+
+// applyQoSProvider resolves name/class against the registry and, for
+// container-scope providers, returns a SpecOpt that applies it. It returns
+// ok == false if no provider is registered for name, so callers can fall
+// back to the legacy dummy resource handling during the transition.
+func applyQoSProvider(name, class string) (opt oci.SpecOpts, ok bool, err error) {
+	p, ok := qosProvider(name)
+	if !ok {
+		return nil, false, nil
+	}
+	if p.Level() != ContainerScope {
+		return nil, true, fmt.Errorf("QoS resource %q is not a container-level resource", name)
+	}
+	return qosProviderSpecOpt(p, class), true, nil
+}
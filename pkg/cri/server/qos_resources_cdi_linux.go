@@ -0,0 +1,239 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/container-orchestrated-devices/container-device-interface/pkg/cdi"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/oci"
+	"github.com/fsnotify/fsnotify"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// defaultCDISpecDirs are scanned for CDI specs when the user has not
+// configured explicit search paths.
+var defaultCDISpecDirs = []string{"/etc/cdi", "/var/run/cdi"}
+
+// cdiProvider is a QoSResourceProvider backed by the CDI devices of a
+// single vendor/class, e.g. "sriov.example.com/nic". One instance is
+// registered per resource name discovered on disk.
+type cdiProvider struct {
+	name     string
+	registry cdi.Registry
+
+	mu      sync.RWMutex
+	classes map[string]string // class (CDI device name) -> fully qualified CDI device name
+
+	watchersMu sync.Mutex
+	watchers   []chan Event
+}
+
+func (p *cdiProvider) Name() string { return p.name }
+func (p *cdiProvider) Level() Scope { return ContainerScope }
+
+func (p *cdiProvider) Classes() []Class {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := make([]Class, 0, len(p.classes))
+	for class := range p.classes {
+		out = append(out, Class{Name: class})
+	}
+	return out
+}
+
+func (p *cdiProvider) Apply(class string, spec *specs.Spec) error {
+	p.mu.RLock()
+	device, ok := p.classes[class]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown %s class %q: no matching CDI device", p.name, class)
+	}
+
+	// Merge the device's containerEdits via the oci.WithCDIDevices SpecOpt,
+	// the same way any other CDI device injection is wired into the spec,
+	// rather than poking the registry directly.
+	if err := oci.WithCDIDevices(device)(context.Background(), nil, nil, spec); err != nil {
+		return fmt.Errorf("failed to inject CDI device %q: %w", device, err)
+	}
+	return nil
+}
+
+func (p *cdiProvider) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event, 1)
+
+	p.watchersMu.Lock()
+	p.watchers = append(p.watchers, ch)
+	p.watchersMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		p.watchersMu.Lock()
+		defer p.watchersMu.Unlock()
+		for i, w := range p.watchers {
+			if w == ch {
+				p.watchers = append(p.watchers[:i], p.watchers[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+
+	return ch
+}
+
+// Mutable is false: moving a running container to a different CDI device
+// requires re-injecting device nodes, mounts and hooks, which we only do
+// at container creation.
+func (p *cdiProvider) Mutable() bool { return false }
+
+func (p *cdiProvider) notify() {
+	p.watchersMu.Lock()
+	defer p.watchersMu.Unlock()
+	for _, w := range p.watchers {
+		select {
+		case w <- Event{Kind: EventClassesChanged}:
+		default:
+		}
+	}
+}
+
+// initCDIQoSResources scans dirs for CDI specs and registers a
+// QoSResourceProvider per vendor/class found, then starts a watcher so the
+// class lists stay in sync with the spec files on disk. If dirs is empty,
+// defaultCDISpecDirs is used.
+func (c *criService) initCDIQoSResources(dirs []string) error {
+	if len(dirs) == 0 {
+		dirs = defaultCDISpecDirs
+	}
+
+	registry := cdi.GetRegistry(cdi.WithSpecDirs(dirs...))
+	if err := registry.Configure(); err != nil {
+		return fmt.Errorf("failed to configure CDI registry for QoS resources: %w", err)
+	}
+
+	if err := refreshCDIQoSProviders(registry); err != nil {
+		return err
+	}
+
+	return watchCDISpecDirs(dirs, func() error {
+		if err := registry.Refresh(); err != nil {
+			return fmt.Errorf("failed to refresh CDI registry: %w", err)
+		}
+		return refreshCDIQoSProviders(registry)
+	})
+}
+
+// cdiQoSProviders tracks the providers we ourselves registered, so a
+// refresh can unregister ones whose backing CDI spec disappeared.
+var (
+	cdiQoSProvidersMu sync.Mutex
+	cdiQoSProviders   = map[string]*cdiProvider{}
+)
+
+// refreshCDIQoSProviders rebuilds the set of CDI-backed QoS providers from
+// the devices known to registry, registering new ones, updating existing
+// ones in place (so Watch subscribers are notified rather than dropped),
+// and unregistering ones with no devices left.
+func refreshCDIQoSProviders(registry cdi.Registry) error {
+	resources := make(map[string]map[string]string)
+	for _, device := range registry.ListDevices() {
+		vendor, class, name, err := cdi.ParseQualifiedName(device)
+		if err != nil {
+			log.L.Warnf("skipping CDI device %q for QoS resources: %v", device, err)
+			continue
+		}
+		resourceName := vendor + "/" + class
+		classes, ok := resources[resourceName]
+		if !ok {
+			classes = make(map[string]string)
+			resources[resourceName] = classes
+		}
+		classes[name] = device
+	}
+
+	cdiQoSProvidersMu.Lock()
+	defer cdiQoSProvidersMu.Unlock()
+
+	for name, classes := range resources {
+		p, ok := cdiQoSProviders[name]
+		if !ok {
+			p = &cdiProvider{name: name, registry: registry}
+			cdiQoSProviders[name] = p
+			RegisterQoSProvider(p)
+		}
+		p.mu.Lock()
+		p.classes = classes
+		p.mu.Unlock()
+		p.notify()
+	}
+
+	for name, p := range cdiQoSProviders {
+		if _, stillPresent := resources[name]; !stillPresent {
+			UnregisterQoSProvider(name)
+			delete(cdiQoSProviders, name)
+		}
+	}
+
+	log.L.Infof("refreshed CDI QoS resources: %d resource types", len(resources))
+	return nil
+}
+
+// watchCDISpecDirs watches dirs for CDI spec changes and calls onChange
+// whenever a spec file is created, written, removed or renamed.
+func watchCDISpecDirs(dirs []string, onChange func() error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create CDI spec watcher: %w", err)
+	}
+
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			log.L.Warnf("not watching CDI spec dir %q: %v", dir, err)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event, open := <-watcher.Events:
+				if !open {
+					return
+				}
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+				if err := onChange(); err != nil {
+					log.L.Errorf("failed to reload CDI QoS resources after %s: %v", event, err)
+				}
+			case err, open := <-watcher.Errors:
+				if !open {
+					return
+				}
+				log.L.Errorf("CDI spec watcher error: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
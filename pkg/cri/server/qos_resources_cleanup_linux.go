@@ -0,0 +1,87 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+)
+
+// allQoSResourceNames returns every resource name currently known at level,
+// from registered providers as well as the legacy dummy resources, so
+// teardown can release whichever of them an owner happened to hold.
+func allQoSResourceNames(level Scope) []string {
+	seen := make(map[string]struct{})
+	for _, p := range qosProvidersAt(level) {
+		seen[p.Name()] = struct{}{}
+	}
+
+	dummies := dummyPodQoSResources
+	if level == ContainerScope {
+		dummies = dummyContainerQoSResources
+	}
+	for name := range dummies {
+		seen[name] = struct{}{}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ReleasePodSandboxQoSResources releases every QoS resource class
+// sandboxID was holding. It must be called once a pod sandbox has been
+// removed, so the capacity it occupied becomes available to new pods.
+func ReleasePodSandboxQoSResources(sandboxID string) {
+	alloc := podQoSAllocator()
+	for _, name := range allQoSResourceNames(PodScope) {
+		if class, ok := alloc.classFor(name, sandboxID); ok {
+			alloc.release(name, class, sandboxID)
+		}
+	}
+}
+
+// ReleaseContainerQoSResources releases every QoS resource class
+// containerID was holding. It must be called once a container has been
+// removed, so the capacity it occupied becomes available to new containers.
+func ReleaseContainerQoSResources(containerID string) {
+	alloc := containerQoSAllocator()
+	for _, name := range allQoSResourceNames(ContainerScope) {
+		if class, ok := alloc.classFor(name, containerID); ok {
+			alloc.release(name, class, containerID)
+		}
+	}
+}
+
+// releasePodSandboxQoSResources must be called from the existing
+// RemovePodSandbox implementation, alongside its other teardown steps
+// (network and snapshot cleanup, store removal), so the QoS capacity the
+// sandbox was holding becomes available to new pods.
+func (c *criService) releasePodSandboxQoSResources(ctx context.Context, sandboxID string) {
+	ReleasePodSandboxQoSResources(sandboxID)
+}
+
+// releaseContainerQoSResources must be called from the existing
+// RemoveContainer implementation, alongside its other teardown steps
+// (snapshot and store removal), so the QoS capacity the container was
+// holding becomes available to new containers.
+func (c *criService) releaseContainerQoSResources(ctx context.Context, containerID string) {
+	ReleaseContainerQoSResources(containerID)
+}
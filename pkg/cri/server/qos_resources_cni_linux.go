@@ -0,0 +1,331 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	cni "github.com/containerd/go-cni"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	// QoSResourceNet is the name of the CNI QoS resource
+	QoSResourceNet = "net"
+)
+
+// supportedQdiscs are the queueing disciplines the bandwidth-limited net
+// classes may request. Besides the default token-bucket filter ("tbf")
+// that go-cni's bandwidth capability programs, "htb" and "fq_codel" are
+// supported when the network's plugin chain includes a plugin capable of
+// programming them.
+var supportedQdiscs = map[string]bool{
+	"tbf":      true,
+	"htb":      true,
+	"fq_codel": true,
+}
+
+// CniQoSClass describes one selectable network QoS class: a capacity, the
+// per-direction bandwidth shaping parameters, and optional DSCP marking
+// and queue discipline.
+type CniQoSClass struct {
+	// Capacity is the max number of simultaneous pods that can use this class
+	Capacity uint64
+
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	EgressRate   uint64 `json:"egressRate,omitempty"`
+	EgressBurst  uint64 `json:"egressBurst,omitempty"`
+
+	// DSCP is the Differentiated Services Code Point to mark egress
+	// packets with. A nil value leaves packets unmarked.
+	DSCP *uint8 `json:"dscp,omitempty"`
+
+	// Qdisc is the queueing discipline for this class: one of "tbf" (the
+	// go-cni bandwidth plugin default), "htb" or "fq_codel". It is checked
+	// against the network's plugin chain when the configuration is parsed;
+	// it is not passed to the plugin chain as a capability arg.
+	Qdisc string `json:"qdisc,omitempty"`
+}
+
+func (c CniQoSClass) bandwidth() *cni.BandWidth {
+	if c.IngressRate == 0 && c.IngressBurst == 0 && c.EgressRate == 0 && c.EgressBurst == 0 {
+		return nil
+	}
+	return &cni.BandWidth{
+		IngressRate:  c.IngressRate,
+		IngressBurst: c.IngressBurst,
+		EgressRate:   c.EgressRate,
+		EgressBurst:  c.EgressBurst,
+	}
+}
+
+// cniQoSClass pairs a CniQoSClass with the name of the network it was
+// configured on, so classes from different networks in a multi-network
+// pod don't collide. canonical is the namespaced class name this entry is
+// stored under in cniQoSResource; a bare-name alias entry still carries
+// its canonical namespaced name here, so capacity accounting is keyed
+// consistently no matter which alias a pod requested.
+type cniQoSClass struct {
+	network   string
+	class     CniQoSClass
+	canonical string
+}
+
+// cniQoSResource maps a namespaced class name ("<network>/<class>") to its
+// definition. Classes from the pod's primary network are also exposed
+// under their bare name for backwards compatibility with single-network
+// configurations; both aliases share the same cniQoSClass.canonical, so
+// capacity is tracked once regardless of which name a pod used.
+var cniQoSResource map[string]cniQoSClass
+
+func namespacedClassName(network, class string) string {
+	return network + "/" + class
+}
+
+// cniNetQoSProvider is the QoSResourceProvider for the built-in CNI net
+// resource. Unlike container-scope providers it is never dispatched to via
+// Apply: pod-level network QoS is applied by generateCniQoSResourceOpts as
+// CNI namespace options, since it has to reach the CNI plugin chain rather
+// than the OCI spec.
+type cniNetQoSProvider struct{}
+
+func (cniNetQoSProvider) Name() string { return QoSResourceNet }
+func (cniNetQoSProvider) Level() Scope { return PodScope }
+
+func (cniNetQoSProvider) Classes() []Class {
+	seen := make(map[string]struct{}, len(cniQoSResource))
+	out := make([]Class, 0, len(cniQoSResource))
+	for _, c := range cniQoSResource {
+		if _, ok := seen[c.canonical]; ok {
+			continue
+		}
+		seen[c.canonical] = struct{}{}
+		out = append(out, Class{Name: c.canonical, Capacity: c.class.Capacity})
+	}
+	return out
+}
+
+func (cniNetQoSProvider) Apply(class string, spec *specs.Spec) error {
+	return fmt.Errorf("%q is a pod-level QoS resource applied via CNI, not the OCI spec", QoSResourceNet)
+}
+
+// Watch is a no-op: the CNI QoS class list is refreshed wholesale by
+// updateCniQoSResources whenever the CNI configuration is reloaded.
+func (cniNetQoSProvider) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// Mutable is true: a pod can be moved to a different net class by
+// reprogramming the tc filters on its veth, without recreating the
+// sandbox.
+func (cniNetQoSProvider) Mutable() bool { return true }
+
+func init() {
+	RegisterQoSProvider(cniNetQoSProvider{})
+}
+
+// reprogramSandboxNetQoS moves the pod sandbox's network namespace to
+// class by re-invoking the CNI plugin chain with the class's bandwidth
+// and DSCP capability args, reprogramming the tc filters on the pod's
+// veth without recreating the sandbox.
+func reprogramSandboxNetQoS(ctx context.Context, netplugin cni.CNI, sandboxID, netNSPath string, class string) error {
+	nc, ok := cniQoSResource[class]
+	if !ok {
+		return fmt.Errorf("unknown %q class %q", QoSResourceNet, class)
+	}
+
+	opts := []cni.NamespaceOpts{}
+	if bw := nc.class.bandwidth(); bw != nil {
+		opts = append(opts, cni.WithCapabilityBandWidth(*bw))
+	}
+	if nc.class.DSCP != nil {
+		opts = append(opts, cni.WithCapability("dscp", *nc.class.DSCP))
+	}
+
+	if _, err := netplugin.CheckNetworkList(ctx, sandboxID, netNSPath, opts...); err != nil {
+		return fmt.Errorf("failed to reprogram net QoS class %q for sandbox %q: %w", class, sandboxID, err)
+	}
+	return nil
+}
+
+// generateCniQoSResourceOpts resolves the pod's requested "net" QoS class
+// into CNI namespace options carrying the full bandwidth and DSCP
+// capability args. The class's qdisc is not among them.
+func generateCniQoSResourceOpts(sandboxID string, config *runtime.PodSandboxConfig) ([]cni.NamespaceOpts, error) {
+	nsOpts := []cni.NamespaceOpts{}
+
+	for _, r := range config.GetQosResources() {
+		if r.GetName() != QoSResourceNet {
+			continue
+		}
+
+		class := r.GetClass()
+		nc, ok := cniQoSResource[class]
+		if !ok {
+			return nil, fmt.Errorf("unknown %q class %q", QoSResourceNet, class)
+		}
+
+		// Reserve against nc.canonical, not the raw requested class: the
+		// pod's primary network exposes its classes under both a
+		// namespaced name and a bare alias, and both must account against
+		// the same capacity or admission could be double-counted.
+		if err := podQoSAllocator().reserve(QoSResourceNet, nc.canonical, sandboxID, nc.class.Capacity); err != nil {
+			return nil, err
+		}
+
+		if bw := nc.class.bandwidth(); bw != nil {
+			nsOpts = append(nsOpts, cni.WithCapabilityBandWidth(*bw))
+		}
+		if nc.class.DSCP != nil {
+			nsOpts = append(nsOpts, cni.WithCapability("dscp", *nc.class.DSCP))
+		}
+		break
+	}
+	return nsOpts, nil
+}
+
+// updateCniQoSResources rebuilds cniQoSResource from netplugin's current
+// configuration.
+func updateCniQoSResources(netplugin cni.CNI) error {
+	qos, err := getCniQoSResources(netplugin)
+	if err != nil {
+		return err
+	}
+	cniQoSResource = qos
+	return nil
+}
+
+// getCniQoSResources walks every configured network (not just a single
+// hard-coded secondary one) and merges their "qos" maps, namespacing each
+// class by the network's name so a pod + secondary SR-IOV net setup can
+// each contribute classes without colliding.
+func getCniQoSResources(netplugin cni.CNI) (map[string]cniQoSClass, error) {
+	if netplugin == nil {
+		return nil, fmt.Errorf("BUG: unable to parse CNI QoS resources, nil plugin was given")
+	}
+
+	cniConfig := netplugin.GetConfig()
+	if len(cniConfig.Networks) == 0 {
+		return nil, fmt.Errorf("unable to parse CNI config for QoS resources: no networks configured")
+	}
+
+	sources := make([]cniNetworkQoSSource, len(cniConfig.Networks))
+	for i, network := range cniConfig.Networks {
+		pluginSources := make([]string, len(network.Config.Plugins))
+		for j, p := range network.Config.Plugins {
+			pluginSources[j] = p.Source
+		}
+		sources[i] = cniNetworkQoSSource{configSource: network.Config.Source, pluginSources: pluginSources}
+	}
+
+	return mergeCniQoSClasses(sources)
+}
+
+// cniNetworkQoSSource is the raw, unparsed configuration of one CNI network
+// that mergeCniQoSClasses needs: the network's own config (to find its
+// "qos" map) and its plugin chain's configs (to validate qdisc support).
+// Keeping this decoupled from cni.Network lets the merge/validation logic
+// be unit tested without a real CNI plugin chain.
+type cniNetworkQoSSource struct {
+	configSource  string
+	pluginSources []string
+}
+
+// mergeCniQoSClasses is the testable core of getCniQoSResources: given the
+// already-loaded CNI networks, it parses each one's "qos" map and merges
+// them into a single namespaced class table.
+func mergeCniQoSClasses(networks []cniNetworkQoSSource) (map[string]cniQoSClass, error) {
+	merged := make(map[string]cniQoSClass)
+	for i, network := range networks {
+		tmp := struct {
+			Name string                 `json:"name,omitempty"`
+			Qos  map[string]CniQoSClass `json:"qos,omitempty"`
+		}{}
+		if err := json.Unmarshal([]byte(network.configSource), &tmp); err != nil {
+			return nil, fmt.Errorf("failed to parse CNI config for network %d for QoS resources: %w", i, err)
+		}
+		if len(tmp.Qos) == 0 {
+			continue
+		}
+
+		netName := tmp.Name
+		if netName == "" {
+			netName = fmt.Sprintf("network-%d", i)
+		}
+
+		for className, c := range tmp.Qos {
+			if c.Qdisc != "" {
+				if !supportedQdiscs[c.Qdisc] {
+					return nil, fmt.Errorf("network %q class %q: unsupported qdisc %q", netName, className, c.Qdisc)
+				}
+				if err := validateQdiscSupportedByChain(network.pluginSources, c.Qdisc); err != nil {
+					return nil, fmt.Errorf("network %q class %q: %w", netName, className, err)
+				}
+			}
+
+			canonical := namespacedClassName(netName, className)
+			entry := cniQoSClass{network: netName, class: c, canonical: canonical}
+			merged[canonical] = entry
+
+			// The pod's primary network (the first one configured) also
+			// exposes its classes under their bare name, preserving
+			// single-network configurations that predate namespacing.
+			if i == 0 {
+				merged[className] = entry
+			}
+		}
+	}
+
+	logrus.Infof("parsed CNI QoS config: %d classes across %d networks", len(merged), len(networks))
+	return merged, nil
+}
+
+// validateQdiscSupportedByChain checks that qdisc is something the
+// network's loaded CNI plugin chain can actually program: "tbf" only
+// requires the standard bandwidth plugin, while "htb" and "fq_codel"
+// require a plugin whose type names the qdisc to be present in the chain.
+func validateQdiscSupportedByChain(pluginSources []string, qdisc string) error {
+	if qdisc == "tbf" {
+		return nil
+	}
+
+	for _, source := range pluginSources {
+		var pluginType struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal([]byte(source), &pluginType); err != nil {
+			continue
+		}
+		if strings.Contains(pluginType.Type, qdisc) {
+			return nil
+		}
+	}
+	return fmt.Errorf("qdisc %q is not supported by the loaded CNI plugin chain", qdisc)
+}
@@ -0,0 +1,128 @@
+//go:build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import "testing"
+
+func qosSource(name, rawQos string) cniNetworkQoSSource {
+	return cniNetworkQoSSource{configSource: `{"name":"` + name + `","qos":` + rawQos + `}`}
+}
+
+func TestMergeCniQoSClassesNamespacesPrimaryNetworkUnderBothNames(t *testing.T) {
+	networks := []cniNetworkQoSSource{
+		qosSource("net0", `{"gold":{"Capacity":1}}`),
+	}
+
+	merged, err := mergeCniQoSClasses(networks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	namespaced, ok := merged["net0/gold"]
+	if !ok {
+		t.Fatalf("expected namespaced entry %q", "net0/gold")
+	}
+	bare, ok := merged["gold"]
+	if !ok {
+		t.Fatalf("expected bare-name alias entry %q", "gold")
+	}
+	if namespaced.canonical != bare.canonical {
+		t.Errorf("alias canonical keys differ: %q vs %q", namespaced.canonical, bare.canonical)
+	}
+	if namespaced.canonical != "net0/gold" {
+		t.Errorf("canonical = %q, want %q", namespaced.canonical, "net0/gold")
+	}
+}
+
+func TestMergeCniQoSClassesSecondaryNetworkNotAliased(t *testing.T) {
+	networks := []cniNetworkQoSSource{
+		qosSource("net0", `{"gold":{"Capacity":1}}`),
+		qosSource("net1", `{"silver":{"Capacity":2}}`),
+	}
+
+	merged, err := mergeCniQoSClasses(networks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := merged["silver"]; ok {
+		t.Errorf("secondary network class should not get a bare-name alias")
+	}
+	if _, ok := merged["net1/silver"]; !ok {
+		t.Errorf("expected namespaced entry %q", "net1/silver")
+	}
+}
+
+func TestMergeCniQoSClassesRejectsUnsupportedQdisc(t *testing.T) {
+	networks := []cniNetworkQoSSource{
+		qosSource("net0", `{"gold":{"Capacity":1,"qdisc":"nonexistent"}}`),
+	}
+
+	if _, err := mergeCniQoSClasses(networks); err == nil {
+		t.Fatalf("expected an error for an unsupported qdisc")
+	}
+}
+
+func TestMergeCniQoSClassesTbfNeedsNoPluginSupport(t *testing.T) {
+	networks := []cniNetworkQoSSource{
+		qosSource("net0", `{"gold":{"Capacity":1,"qdisc":"tbf"}}`),
+	}
+
+	merged, err := mergeCniQoSClasses(networks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(merged) != 2 { // namespaced + bare alias
+		t.Errorf("len(merged) = %d, want 2", len(merged))
+	}
+}
+
+func TestValidateQdiscSupportedByChain(t *testing.T) {
+	if err := validateQdiscSupportedByChain(nil, "tbf"); err != nil {
+		t.Errorf("tbf should never need plugin chain support: %v", err)
+	}
+
+	htbPlugins := []string{`{"type":"htb-shaper"}`}
+	if err := validateQdiscSupportedByChain(htbPlugins, "htb"); err != nil {
+		t.Errorf("htb plugin in chain: unexpected error: %v", err)
+	}
+
+	if err := validateQdiscSupportedByChain(htbPlugins, "fq_codel"); err == nil {
+		t.Errorf("expected an error: chain has no fq_codel-capable plugin")
+	}
+}
+
+func TestCniNetQoSProviderClassesDeduplicatesAliases(t *testing.T) {
+	old := cniQoSResource
+	defer func() { cniQoSResource = old }()
+
+	canonical := "net0/gold"
+	cniQoSResource = map[string]cniQoSClass{
+		canonical: {network: "net0", class: CniQoSClass{Capacity: 1}, canonical: canonical},
+		"gold":    {network: "net0", class: CniQoSClass{Capacity: 1}, canonical: canonical},
+	}
+
+	classes := cniNetQoSProvider{}.Classes()
+	if len(classes) != 1 {
+		t.Fatalf("len(classes) = %d, want 1 (aliases should be deduplicated)", len(classes))
+	}
+	if classes[0].Name != canonical {
+		t.Errorf("classes[0].Name = %q, want %q", classes[0].Name, canonical)
+	}
+}
@@ -17,31 +17,17 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/oci"
 	"github.com/containerd/containerd/pkg/blockio"
 	"github.com/containerd/containerd/pkg/rdt"
-	cni "github.com/containerd/go-cni"
 	"github.com/sirupsen/logrus"
 	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
-const (
-	// QoSResourceNet is the name of the CNI QoS resource
-	QoSResourceNet = "net"
-)
-
-type CniQoSClass struct {
-	// Capacity is the max number of simultaneous pods that can use this class
-	Capacity  uint64
-	BandWidth *cni.BandWidth
-}
-
-var cniQoSResource map[string]CniQoSClass
-
 // HACK: dummyQoS resources
 var dummyContainerQoSResourcesInfo []*runtime.QOSResourceInfo
 var dummyContainerQoSResources map[string]map[string]struct{}
@@ -49,9 +35,16 @@ var dummyContainerQoSResources map[string]map[string]struct{}
 var dummyPodQoSResourcesInfo []*runtime.QOSResourceInfo
 var dummyPodQoSResources map[string]map[string]struct{}
 
-// generateSandboxQoSResourceSpecOpts generates SpecOpts for QoS resources.
-func (c *criService) generateSandboxQoSResourceSpecOpts(config *runtime.PodSandboxConfig) ([]oci.SpecOpts, error) {
+// generateSandboxQoSResourceSpecOpts generates SpecOpts for QoS resources,
+// admitting the sandbox (identified by sandboxID) against the capacity of
+// each requested class.
+func (c *criService) generateSandboxQoSResourceSpecOpts(ctx context.Context, sandboxID string, config *runtime.PodSandboxConfig) ([]oci.SpecOpts, error) {
+	if err := c.ensureQoSResourceProvidersInitialized(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize QoS resource providers: %w", err)
+	}
+
 	specOpts := []oci.SpecOpts{}
+	podInfos := GetPodQoSResourcesInfo()
 
 	for _, r := range config.GetQosResources() {
 		name := r.GetName()
@@ -60,6 +53,17 @@ func (c *criService) generateSandboxQoSResourceSpecOpts(config *runtime.PodSandb
 		case QoSResourceNet:
 			// Network QoS is handled in generateCniQoSResourceOpts()
 		default:
+			if p, ok := qosProvider(name); ok {
+				if p.Level() != PodScope {
+					return nil, fmt.Errorf("QoS resource %q is not a pod-level resource", name)
+				}
+				// Pod-level providers other than "net" are applied
+				// through their own provider-specific mechanism rather
+				// than a SpecOpt; this dispatcher only validates the
+				// assignment and accounts for its capacity below.
+				break
+			}
+
 			cr, ok := dummyPodQoSResources[name]
 			if !ok {
 				return nil, fmt.Errorf("unknown pod-level QoS resource type %q", name)
@@ -73,32 +77,31 @@ func (c *criService) generateSandboxQoSResourceSpecOpts(config *runtime.PodSandb
 		if class == "" {
 			return nil, fmt.Errorf("empty class name not allowed for QoS resource type %q", name)
 		}
-	}
-	return specOpts, nil
-}
 
-func generateCniQoSResourceOpts(config *runtime.PodSandboxConfig) ([]cni.NamespaceOpts, error) {
-	nsOpts := []cni.NamespaceOpts{}
+		// "net" capacity is reserved by generateCniQoSResourceOpts under
+		// the class's canonical name.
+		if name == QoSResourceNet {
+			continue
+		}
 
-	for _, r := range config.GetQosResources() {
-		if r.GetName() == QoSResourceNet {
-			class := r.GetClass()
-			caps, ok := cniQoSResource[class]
-			if !ok {
-				return nil, fmt.Errorf("unknown %q class %q", QoSResourceNet, class)
-			}
-			if caps.BandWidth != nil {
-				nsOpts = append(nsOpts, cni.WithCapabilityBandWidth(*caps.BandWidth))
-			}
-			break
+		capacity := qosClassCapacity(podInfos, name, class)
+		if err := podQoSAllocator().reserve(name, class, sandboxID, capacity); err != nil {
+			return nil, err
 		}
 	}
-	return nsOpts, nil
+	return specOpts, nil
 }
 
-// generateContainerQoSResourceSpecOpts generates SpecOpts for QoS resources.
-func (c *criService) generateContainerQoSResourceSpecOpts(config *runtime.ContainerConfig, sandboxConfig *runtime.PodSandboxConfig) ([]oci.SpecOpts, error) {
+// generateContainerQoSResourceSpecOpts generates SpecOpts for QoS resources,
+// admitting the container (identified by containerID) against the capacity
+// of each requested class.
+func (c *criService) generateContainerQoSResourceSpecOpts(ctx context.Context, containerID string, config *runtime.ContainerConfig, sandboxConfig *runtime.PodSandboxConfig) ([]oci.SpecOpts, error) {
+	if err := c.ensureQoSResourceProvidersInitialized(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize QoS resource providers: %w", err)
+	}
+
 	specOpts := []oci.SpecOpts{}
+	containerInfos := GetContainerQoSResourcesInfo()
 
 	// Handle QoS resource assignments
 	for _, r := range config.GetQosResources() {
@@ -110,6 +113,13 @@ func (c *criService) generateContainerQoSResourceSpecOpts(config *runtime.Contai
 			// We handle RDT and blockio separately as we have pod and
 			// container annotations as fallback interface
 		default:
+			if opt, ok, err := applyQoSProvider(name, class); err != nil {
+				return nil, err
+			} else if ok {
+				specOpts = append(specOpts, opt)
+				break
+			}
+
 			cr, ok := dummyContainerQoSResources[name]
 			if !ok {
 				return nil, fmt.Errorf("unknown QoS resource type %q", name)
@@ -123,6 +133,11 @@ func (c *criService) generateContainerQoSResourceSpecOpts(config *runtime.Contai
 		if class == "" {
 			return nil, fmt.Errorf("empty class name not allowed for QoS resource type %q", name)
 		}
+
+		capacity := qosClassCapacity(containerInfos, name, class)
+		if err := containerQoSAllocator().reserve(name, class, containerID, capacity); err != nil {
+			return nil, err
+		}
 	}
 
 	// Handle RDT
@@ -133,7 +148,9 @@ func (c *criService) generateContainerQoSResourceSpecOpts(config *runtime.Contai
 			return nil, fmt.Errorf("failed to set RDT class: %w", err)
 		}
 	} else if cls != "" {
-		specOpts = append(specOpts, oci.WithRdt(cls, "", ""))
+		if p, ok := qosProvider(runtime.QoSResourceRdt); ok {
+			specOpts = append(specOpts, qosProviderSpecOpt(p, cls))
+		}
 	}
 
 	// Handle Block IO
@@ -144,31 +161,94 @@ func (c *criService) generateContainerQoSResourceSpecOpts(config *runtime.Contai
 			return nil, fmt.Errorf("failed to set blockio class: %w", err)
 		}
 	} else if cls != "" {
-		if linuxBlockIO, err := blockio.ClassNameToLinuxOCI(cls); err == nil {
-			specOpts = append(specOpts, oci.WithBlockIO(linuxBlockIO))
-		} else {
-			return nil, err
+		if p, ok := qosProvider(runtime.QoSResourceBlockio); ok {
+			specOpts = append(specOpts, qosProviderSpecOpt(p, cls))
 		}
 	}
 
 	return specOpts, nil
 }
 
+// updateContainerQoSResources reclassifies the running container
+// containerID into the classes given by resources, without restarting it,
+// and notifies kubelet of the change. Only resources whose provider
+// advertises Mutable() may be changed this way; anything else is
+// rejected, since it would require recreating the container.
+func (c *criService) updateContainerQoSResources(ctx context.Context, containerID string, resources []*runtime.QOSResource) error {
+	cntr, err := c.containerStore.Get(containerID)
+	if err != nil {
+		return fmt.Errorf("failed to find container %q: %w", containerID, err)
+	}
+	containerInfos := GetContainerQoSResourcesInfo()
+
+	for _, r := range resources {
+		name, class := r.GetName(), r.GetClass()
+
+		p, ok := qosProvider(name)
+		if !ok {
+			return fmt.Errorf("unknown QoS resource type %q", name)
+		}
+		if !p.Mutable() {
+			return fmt.Errorf("QoS resource %q does not support in-place updates", name)
+		}
+
+		// classFor must be captured before reserve: once the new class
+		// is reserved, containerID is present under both the old and
+		// new class buckets and classFor's result becomes ambiguous.
+		oldClass, hadOldClass := containerQoSAllocator().classFor(name, containerID)
+
+		// Reserve capacity before physically moving the container: if the
+		// target class is full, the container must be left untouched
+		// rather than moved and then reported as failed.
+		capacity := qosClassCapacity(containerInfos, name, class)
+		if err := containerQoSAllocator().reserve(name, class, containerID, capacity); err != nil {
+			return err
+		}
+
+		var moveErr error
+		switch name {
+		case runtime.QoSResourceRdt:
+			moveErr = moveContainerRdtClass(containerID, class)
+		case runtime.QoSResourceBlockio:
+			moveErr = moveContainerBlockioClass(containerID, class)
+		case QoSResourceNet:
+			moveErr = reprogramSandboxNetQoS(ctx, c.netPlugin, cntr.SandboxID, cntr.NetNS.GetPath(), class)
+		default:
+			moveErr = fmt.Errorf("provider %q does not implement in-place reclassification", name)
+		}
+		if moveErr != nil {
+			// Roll back the reservation: the container never actually
+			// moved, so it must not be left accounted for under the new
+			// class. Skip this if it was already the container's class
+			// before this call, so a failed retry doesn't evict a
+			// reservation that predates it.
+			if !hadOldClass || oldClass != class {
+				containerQoSAllocator().release(name, class, containerID)
+			}
+			return fmt.Errorf("failed to move container %q to %s class %q: %w", containerID, name, class, moveErr)
+		}
+
+		if hadOldClass && oldClass != class {
+			containerQoSAllocator().release(name, oldClass, containerID)
+		}
+
+		if err := c.generateAndSendContainerEvent(ctx, containerID, cntr.SandboxID, runtime.ContainerEventType_CONTAINER_RESOURCE_UPDATED_EVENT); err != nil {
+			log.L.Errorf("failed to send resource update event for container %q: %v", containerID, err)
+		}
+	}
+
+	return nil
+}
+
 // GetPodQoSResourcesInfo returns information about all pod-level QoS resources.
 func GetPodQoSResourcesInfo() []*runtime.QOSResourceInfo {
 	info := []*runtime.QOSResourceInfo{}
 
-	if len(cniQoSResource) > 0 {
-		classes := make([]*runtime.QOSResourceClassInfo, 0, len(cniQoSResource))
-		for n, c := range cniQoSResource {
-			classes = append(classes, &runtime.QOSResourceClassInfo{Name: n, Capacity: c.Capacity})
+	for _, p := range qosProvidersAt(PodScope) {
+		if len(p.Classes()) == 0 {
+			continue
 		}
-
-		info = append(info, &runtime.QOSResourceInfo{
-			Name:    QoSResourceNet,
-			Mutable: false,
-			Classes: classes,
-		})
+		info = append(info, qosResourceInfoFor(p))
 	}
 
 	info = append(info, dummyPodQoSResourcesInfo...)
@@ -179,24 +259,11 @@ func GetPodQoSResourcesInfo() []*runtime.QOSResourceInfo {
 func GetContainerQoSResourcesInfo() []*runtime.QOSResourceInfo {
 	info := []*runtime.QOSResourceInfo{}
 
-	// Handle RDT
-	if classes := rdt.GetClasses(); len(classes) > 0 {
-		info = append(info,
-			&runtime.QOSResourceInfo{
-				Name:    runtime.QoSResourceRdt,
-				Mutable: false,
-				Classes: createClassInfos(classes...),
-			})
-	}
-
-	// Handle blockio
-	if classes := blockio.GetClasses(); len(classes) > 0 {
-		info = append(info,
-			&runtime.QOSResourceInfo{
-				Name:    runtime.QoSResourceBlockio,
-				Mutable: false,
-				Classes: createClassInfos(classes...),
-			})
+	for _, p := range qosProvidersAt(ContainerScope) {
+		if len(p.Classes()) == 0 {
+			continue
+		}
+		info = append(info, qosResourceInfoFor(p))
 	}
 
 	info = append(info, dummyContainerQoSResourcesInfo...)
@@ -204,46 +271,6 @@ func GetContainerQoSResourcesInfo() []*runtime.QOSResourceInfo {
 	return info
 }
 
-func updateCniQoSResources(netplugin cni.CNI) error {
-	qos, err := getCniQoSResources(netplugin)
-	if err != nil {
-		return err
-	}
-	cniQoSResource = qos
-	return nil
-}
-
-func getCniQoSResources(netplugin cni.CNI) (map[string]CniQoSClass, error) {
-	if netplugin == nil {
-		return nil, fmt.Errorf("BUG: unable to parse CNI QoS resources, nil plugin was given")
-	}
-
-	cniConfig := netplugin.GetConfig()
-	if len(cniConfig.Networks) < 2 {
-		return nil, fmt.Errorf("unable to parse CNI config for QoS resources: no networks configured")
-	}
-	rawConf := cniConfig.Networks[1].Config.Source
-
-	/*if len(cniConfig.Networks[1].Config.Plugins) == 0 {
-		return nil, fmt.Errorf("unable to parse CNI config for QoS resources: no plugin configuration found in network")
-	}
-	rawConf := cniConfig.Networks[1].Config.Plugins[0].Source*/
-
-	tmp := struct {
-		Name string                 `json:"name,omitempty"`
-		Qos  map[string]CniQoSClass `json:"qos,omitempty"`
-	}{}
-	logrus.Infof("parsing CNI  QoS config: %s", rawConf)
-
-	if err := json.Unmarshal([]byte(rawConf), &tmp); err != nil {
-		logrus.Infof("failed to parse CNI config: %s", rawConf)
-		return nil, fmt.Errorf("failed to parse CNI config for QoS resources: %w", err)
-	}
-
-	logrus.Infof("parsed CNI  QoS config: %s", tmp)
-
-	return tmp.Qos, nil
-}
 func createClassInfos(names ...string) []*runtime.QOSResourceClassInfo {
 	out := make([]*runtime.QOSResourceClassInfo, len(names))
 	for i, name := range names {
@@ -19,10 +19,12 @@
 package server
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/pkg/rdt"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	runtime "k8s.io/cri-api/pkg/apis/runtime/v1"
 )
 
@@ -62,3 +64,65 @@ func (c *criService) getContainerRdtClass(config *runtime.ContainerConfig, sandb
 
 	return
 }
+
+// rdtQoSProvider is the QoSResourceProvider for the built-in RDT resource
+// type. Class resolution for RDT still goes through getContainerRdtClass,
+// which also consults annotations as a fallback; this provider only
+// supplies the class list and applies the final, already-resolved class.
+type rdtQoSProvider struct{}
+
+func (rdtQoSProvider) Name() string { return runtime.QoSResourceRdt }
+func (rdtQoSProvider) Level() Scope { return ContainerScope }
+
+func (rdtQoSProvider) Classes() []Class {
+	names := rdt.GetClasses()
+	out := make([]Class, len(names))
+	for i, n := range names {
+		out[i] = Class{Name: n}
+	}
+	return out
+}
+
+func (rdtQoSProvider) Apply(class string, spec *specs.Spec) error {
+	if !rdt.IsEnabled() {
+		return fmt.Errorf("RDT disabled, refusing to set RDT class to %q", class)
+	}
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	spec.Linux.IntelRdt = &specs.LinuxIntelRdt{ClosID: class}
+	return nil
+}
+
+// Watch is a no-op: RDT classes come from static containerd configuration
+// and only change on a config reload, which is out of scope for this
+// provider until RDT config hot-reload is supported.
+func (rdtQoSProvider) Watch(ctx context.Context) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// Mutable is true: a running container can be moved to a different RDT
+// class by rewriting its resctrl group membership, without restarting it.
+func (rdtQoSProvider) Mutable() bool { return true }
+
+// moveContainerRdtClass moves the already-running container containerID
+// into class by rewriting its resctrl group membership, without
+// restarting it.
+func moveContainerRdtClass(containerID, class string) error {
+	if !rdt.IsEnabled() {
+		return fmt.Errorf("RDT disabled, refusing to move container %q to class %q", containerID, class)
+	}
+	if !rdt.ClassExists(class) {
+		return fmt.Errorf("invalid RDT class %q: not specified in configuration", class)
+	}
+	return rdt.SetContainerClass(containerID, class)
+}
+
+func init() {
+	RegisterQoSProvider(rdtQoSProvider{})
+}